@@ -0,0 +1,162 @@
+package singleflight
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/authzed/spicedb/pkg/datastore"
+)
+
+var resultCacheCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "spicedb",
+	Subsystem: "dispatch",
+	Name:      "single_flight_result_cache_total",
+	Help:      "total number of dispatch requests served by the pre-singleflight result cache, by method and outcome",
+}, []string{"method", "outcome"})
+
+var resultCacheEvictedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "spicedb",
+	Subsystem: "dispatch",
+	Name:      "single_flight_result_cache_evicted_total",
+	Help:      "total number of entries evicted from the pre-singleflight result cache due to size limits",
+}, []string{"method"})
+
+// RevisionParser parses the serialized AtRevision carried on a dispatch request's metadata into
+// a comparable datastore.Revision, so the result cache can tell whether a cached response is
+// still valid for a newer request.
+type RevisionParser func(serialized string) (datastore.Revision, error)
+
+// resultCacheEntry holds a single cached response, along with the revision it was computed at
+// and when it should be considered expired.
+type resultCacheEntry[V any] struct {
+	key       string
+	revision  datastore.Revision
+	expiresAt time.Time
+	value     V
+}
+
+// resultCache is a bounded, TTL'd, revision-scoped LRU cache of successful dispatch responses.
+// A nil *resultCache is valid and always misses, so the cache can be left disabled without the
+// call sites needing to special-case it.
+type resultCache[V any] struct {
+	method        string
+	maxSize       int
+	ttl           time.Duration
+	parseRevision RevisionParser
+
+	mu    sync.Mutex
+	order *list.List
+	byKey map[string]*list.Element
+}
+
+func newResultCache[V any](method string, maxSize int, ttl time.Duration, parseRevision RevisionParser) *resultCache[V] {
+	return &resultCache[V]{
+		method:        method,
+		maxSize:       maxSize,
+		ttl:           ttl,
+		parseRevision: parseRevision,
+		order:         list.New(),
+		byKey:         make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached value for key, so long as it hasn't expired and atRevision is exactly
+// the revision it was cached at. A request at any other revision misses: CheckDispatchKey and
+// ExpandDispatchKey don't encode revision, so two requests that collide on key can legitimately
+// disagree on membership between revisions, and serving either a newer or an older entry than
+// what the caller actually asked for would silently break exact-snapshot consistency guarantees.
+func (c *resultCache[V]) get(key string, atRevision string) (V, bool) {
+	var zero V
+	if c == nil {
+		return zero, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.byKey[key]
+	if !ok {
+		resultCacheCount.WithLabelValues(c.method, "miss").Inc()
+		return zero, false
+	}
+
+	entry := el.Value.(*resultCacheEntry[V])
+	if time.Now().After(entry.expiresAt) {
+		c.removeLocked(el)
+		resultCacheCount.WithLabelValues(c.method, "miss").Inc()
+		return zero, false
+	}
+
+	// An unparseable request revision is a problem with this request, not evidence that the
+	// cached entry is stale, so it's treated as a miss without evicting the entry.
+	requestRevision, err := c.parseRevision(atRevision)
+	if err != nil {
+		resultCacheCount.WithLabelValues(c.method, "miss").Inc()
+		return zero, false
+	}
+
+	if requestRevision.GreaterThan(entry.revision) {
+		// The entry reflects a revision the world has since moved past; it can never satisfy a
+		// future request either, so evict it now rather than leaving it to expire via TTL.
+		c.removeLocked(el)
+		resultCacheCount.WithLabelValues(c.method, "miss").Inc()
+		return zero, false
+	}
+
+	if !requestRevision.Equal(entry.revision) {
+		// The entry was cached at a revision newer than this request's; it may still be valid for
+		// another caller pinned to that same newer revision, so it's left in place rather than
+		// evicted.
+		resultCacheCount.WithLabelValues(c.method, "miss").Inc()
+		return zero, false
+	}
+
+	c.order.MoveToFront(el)
+	resultCacheCount.WithLabelValues(c.method, "hit").Inc()
+	return entry.value, true
+}
+
+// put stores value under key, scoped to atRevision. If the cache is already at its size limit,
+// the least-recently-used entry is evicted to make room.
+func (c *resultCache[V]) put(key string, atRevision string, value V) {
+	if c == nil {
+		return
+	}
+
+	revision, err := c.parseRevision(atRevision)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.byKey[key]; ok {
+		c.order.MoveToFront(el)
+		entry := el.Value.(*resultCacheEntry[V])
+		entry.revision = revision
+		entry.expiresAt = time.Now().Add(c.ttl)
+		entry.value = value
+		return
+	}
+
+	entry := &resultCacheEntry[V]{key: key, revision: revision, expiresAt: time.Now().Add(c.ttl), value: value}
+	el := c.order.PushFront(entry)
+	c.byKey[key] = el
+
+	for c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.removeLocked(oldest)
+		resultCacheEvictedCount.WithLabelValues(c.method).Inc()
+	}
+}
+
+func (c *resultCache[V]) removeLocked(el *list.Element) {
+	entry := el.Value.(*resultCacheEntry[V])
+	delete(c.byKey, entry.key)
+	c.order.Remove(el)
+}
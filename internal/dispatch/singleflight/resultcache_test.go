@@ -0,0 +1,149 @@
+package singleflight
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/authzed/spicedb/pkg/datastore"
+)
+
+// fakeRevision is a minimal, strictly-ordered datastore.Revision for exercising resultCache's
+// revision-scoping without depending on a concrete datastore implementation.
+type fakeRevision int64
+
+func (r fakeRevision) Equal(rhs datastore.Revision) bool       { return r == rhs.(fakeRevision) }
+func (r fakeRevision) GreaterThan(rhs datastore.Revision) bool { return r > rhs.(fakeRevision) }
+func (r fakeRevision) LessThan(rhs datastore.Revision) bool    { return r < rhs.(fakeRevision) }
+func (r fakeRevision) String() string                          { return strconv.FormatInt(int64(r), 10) }
+
+// parseFakeRevision parses the decimal strings produced by fakeRevision.String back into a
+// fakeRevision, so it can stand in for a real RevisionParser in tests.
+func parseFakeRevision(serialized string) (datastore.Revision, error) {
+	n, err := strconv.ParseInt(serialized, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return fakeRevision(n), nil
+}
+
+func TestResultCacheGetMissesWhenEmpty(t *testing.T) {
+	c := newResultCache[string]("Test", 10, time.Minute, parseFakeRevision)
+
+	_, ok := c.get("key", "1")
+	require.False(t, ok)
+}
+
+func TestResultCacheGetReturnsPutValue(t *testing.T) {
+	c := newResultCache[string]("Test", 10, time.Minute, parseFakeRevision)
+
+	c.put("key", "1", "value")
+
+	v, ok := c.get("key", "1")
+	require.True(t, ok)
+	require.Equal(t, "value", v)
+}
+
+func TestResultCacheInvalidatedByNewerRevision(t *testing.T) {
+	c := newResultCache[string]("Test", 10, time.Minute, parseFakeRevision)
+
+	c.put("key", "1", "value")
+
+	// A request at a later revision than the cached entry must miss, since the cached value may
+	// no longer reflect the state of the world at that revision.
+	_, ok := c.get("key", "2")
+	require.False(t, ok)
+
+	// The stale entry is evicted by the miss above, so even a request back at the original
+	// revision misses too.
+	_, ok = c.get("key", "1")
+	require.False(t, ok)
+}
+
+func TestResultCacheServesExactRevisionMatch(t *testing.T) {
+	c := newResultCache[string]("Test", 10, time.Minute, parseFakeRevision)
+
+	c.put("key", "5", "value")
+
+	v, ok := c.get("key", "5")
+	require.True(t, ok)
+	require.Equal(t, "value", v)
+}
+
+func TestResultCacheMissesOlderRevisionThanCached(t *testing.T) {
+	c := newResultCache[string]("Test", 10, time.Minute, parseFakeRevision)
+
+	c.put("key", "2", "value")
+
+	// A request pinned to an older revision than the cached entry (e.g. replaying an old
+	// zedtoken, or a concurrent request that resolved to an older revision) must not be served
+	// the entry computed at the newer revision: membership can legitimately differ between the
+	// two revisions, and CheckDispatchKey/ExpandDispatchKey don't encode revision into the key.
+	_, ok := c.get("key", "1")
+	require.False(t, ok)
+
+	// The entry is still valid for a request pinned to the revision it was actually cached at.
+	v, ok := c.get("key", "2")
+	require.True(t, ok)
+	require.Equal(t, "value", v)
+}
+
+func TestResultCacheExpiresAfterTTL(t *testing.T) {
+	c := newResultCache[string]("Test", 10, time.Millisecond, parseFakeRevision)
+
+	c.put("key", "1", "value")
+
+	require.Eventually(t, func() bool {
+		_, ok := c.get("key", "1")
+		return !ok
+	}, time.Second, time.Millisecond)
+}
+
+func TestResultCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newResultCache[string]("Test", 2, time.Minute, parseFakeRevision)
+
+	c.put("a", "1", "a-value")
+	c.put("b", "1", "b-value")
+
+	// Touch "a" so it's no longer the least-recently-used entry.
+	_, ok := c.get("a", "1")
+	require.True(t, ok)
+
+	c.put("c", "1", "c-value")
+
+	_, ok = c.get("b", "1")
+	require.False(t, ok, "b should have been evicted as the least-recently-used entry")
+
+	_, ok = c.get("a", "1")
+	require.True(t, ok)
+
+	_, ok = c.get("c", "1")
+	require.True(t, ok)
+}
+
+func TestResultCacheNilIsAlwaysAMiss(t *testing.T) {
+	var c *resultCache[string]
+
+	_, ok := c.get("key", "1")
+	require.False(t, ok)
+
+	// put on a nil cache is a no-op, not a panic.
+	c.put("key", "1", "value")
+}
+
+func TestResultCacheUnparseableRevisionMissesWithoutEvicting(t *testing.T) {
+	c := newResultCache[string]("Test", 10, time.Minute, parseFakeRevision)
+
+	c.put("key", "1", "value")
+
+	_, ok := c.get("key", "not-a-revision")
+	require.False(t, ok)
+
+	// A malformed request revision shouldn't be treated as evidence the cached entry is stale;
+	// a well-formed follow-up request for the same key must still be served from cache.
+	v, ok := c.get("key", "1")
+	require.True(t, ok)
+	require.Equal(t, "value", v)
+}
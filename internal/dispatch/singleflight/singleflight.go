@@ -3,7 +3,10 @@ package singleflight
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -16,6 +19,21 @@ import (
 	v1 "github.com/authzed/spicedb/pkg/proto/dispatch/v1"
 )
 
+// subscriberBufferSize is the number of messages buffered per subscriber of a
+// shared stream. It bounds how far a slow subscriber can lag behind the
+// shared producer before it is dropped from the fan-out.
+const subscriberBufferSize = 64
+
+// defaultMaxSharedStreamHistory is the default value of WithMaxSharedStreamHistory. It bounds how
+// many messages a single shared streaming call (ReachableResources/LookupResources/
+// LookupSubjects) retains in memory to backfill late-joining subscribers, since those methods can
+// legitimately stream result sets far larger than a Check or Expand response.
+const defaultMaxSharedStreamHistory = 10_000
+
+// errSubscriberTooSlow is returned to a subscriber that fell far enough
+// behind the shared producer that its buffer filled up.
+var errSubscriberTooSlow = errors.New("singleflight: subscriber fell behind the shared stream")
+
 var singleFlightCount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Namespace: "spicedb",
 	Subsystem: "dispatch",
@@ -23,8 +41,57 @@ var singleFlightCount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Help:      "total number of dispatch requests that were single flighted",
 }, []string{"method", "shared"})
 
-func New(delegate dispatch.Dispatcher, handler keys.Handler) dispatch.Dispatcher {
-	return &Dispatcher{delegate: delegate, keyHandler: handler}
+var singleFlightStreamCompletedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "spicedb",
+	Subsystem: "dispatch",
+	Name:      "single_flight_stream_completed_total",
+	Help:      "total number of shared streaming dispatch calls whose upstream producer completed",
+}, []string{"method", "errored"})
+
+// Option configures optional behavior on a Dispatcher constructed via New.
+type Option func(*Dispatcher)
+
+// WithResultCache enables a bounded, TTL'd cache of successful DispatchCheck/DispatchExpand
+// responses sitting in front of singleflight coalescing, so that identical requests arriving
+// after the in-flight request has already completed can be served without re-entering the
+// delegate at all. Entries are scoped to the revision they were computed at and invalidated once
+// a later request's AtRevision advances past it; parseRevision is used to compare the two.
+// Without this option, Dispatcher behaves exactly as it did before the result cache existed.
+func WithResultCache(maxSize int, ttl time.Duration, parseRevision RevisionParser) Option {
+	return func(d *Dispatcher) {
+		d.checkCache = newResultCache[*v1.DispatchCheckResponse]("DispatchCheck", maxSize, ttl, parseRevision)
+		d.expandCache = newResultCache[*v1.DispatchExpandResponse]("DispatchExpand", maxSize, ttl, parseRevision)
+	}
+}
+
+// WithMaxSharedStreamHistory bounds how many messages a shared streaming dispatch call
+// (ReachableResources/LookupResources/LookupSubjects) retains for backfilling late-joining
+// subscribers. Once a shared call has published this many messages, it stops accepting
+// new subscribers: a caller arriving after that point can no longer be correctly backfilled, so it
+// runs its own independent delegate call instead of sharing (and instead of silently losing the
+// messages published before it joined). This bounds the memory a single popular dispatch key can
+// hold for the duration of a large streaming fan-out. Defaults to defaultMaxSharedStreamHistory.
+func WithMaxSharedStreamHistory(n int) Option {
+	return func(d *Dispatcher) {
+		d.maxSharedStreamHistory = n
+	}
+}
+
+func New(delegate dispatch.Dispatcher, handler keys.Handler, opts ...Option) dispatch.Dispatcher {
+	d := &Dispatcher{
+		delegate:                   delegate,
+		keyHandler:                 handler,
+		maxSharedStreamHistory:     defaultMaxSharedStreamHistory,
+		reachableResourcesInFlight: make(map[string]*streamShare[*v1.DispatchReachableResourcesResponse]),
+		lookupResourcesInFlight:    make(map[string]*streamShare[*v1.DispatchLookupResourcesResponse]),
+		lookupSubjectsInFlight:     make(map[string]*streamShare[*v1.DispatchLookupSubjectsResponse]),
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
 }
 
 type Dispatcher struct {
@@ -32,6 +99,25 @@ type Dispatcher struct {
 	keyHandler  keys.Handler
 	checkGroup  singleflight.Group[string, *v1.DispatchCheckResponse]
 	expandGroup singleflight.Group[string, *v1.DispatchExpandResponse]
+
+	// checkCache and expandCache are nil unless WithResultCache was passed to New, in which case
+	// the result cache is consulted before the singleflight group and populated after a successful
+	// delegate call.
+	checkCache  *resultCache[*v1.DispatchCheckResponse]
+	expandCache *resultCache[*v1.DispatchExpandResponse]
+
+	// maxSharedStreamHistory bounds how much replay history a shared streaming call retains; see
+	// WithMaxSharedStreamHistory.
+	maxSharedStreamHistory int
+
+	reachableResourcesMu       sync.Mutex
+	reachableResourcesInFlight map[string]*streamShare[*v1.DispatchReachableResourcesResponse]
+
+	lookupResourcesMu       sync.Mutex
+	lookupResourcesInFlight map[string]*streamShare[*v1.DispatchLookupResourcesResponse]
+
+	lookupSubjectsMu       sync.Mutex
+	lookupSubjectsInFlight map[string]*streamShare[*v1.DispatchLookupSubjectsResponse]
 }
 
 func (d *Dispatcher) DispatchCheck(ctx context.Context, req *v1.DispatchCheckRequest) (*v1.DispatchCheckResponse, error) {
@@ -42,6 +128,12 @@ func (d *Dispatcher) DispatchCheck(ctx context.Context, req *v1.DispatchCheckReq
 	}
 
 	keyString := hex.EncodeToString(key)
+	atRevision := requestRevision(req.Metadata)
+
+	if cached, ok := d.checkCache.get(keyString, atRevision); ok {
+		return cached, nil
+	}
+
 	v, isShared, err := d.checkGroup.Do(ctx, keyString, func(innerCtx context.Context) (*v1.DispatchCheckResponse, error) {
 		return d.delegate.DispatchCheck(innerCtx, req)
 	})
@@ -51,6 +143,13 @@ func (d *Dispatcher) DispatchCheck(ctx context.Context, req *v1.DispatchCheckReq
 		return &v1.DispatchCheckResponse{Metadata: &v1.ResponseMeta{DispatchCount: 1}}, err
 	}
 
+	// Responses with caveated members whose caveats reference context can't be safely memoized:
+	// the context they depend on isn't necessarily captured by the dispatch key alone, so caching
+	// them risks serving a stale evaluation to a future caller with different context.
+	if !checkResponseReferencesContext(v) {
+		d.checkCache.put(keyString, atRevision, v)
+	}
+
 	return v, err
 }
 
@@ -62,6 +161,12 @@ func (d *Dispatcher) DispatchExpand(ctx context.Context, req *v1.DispatchExpandR
 	}
 
 	keyString := hex.EncodeToString(key)
+	atRevision := requestRevision(req.Metadata)
+
+	if cached, ok := d.expandCache.get(keyString, atRevision); ok {
+		return cached, nil
+	}
+
 	v, isShared, err := d.expandGroup.Do(ctx, keyString, func(ictx context.Context) (*v1.DispatchExpandResponse, error) {
 		return d.delegate.DispatchExpand(ictx, req)
 	})
@@ -69,20 +174,382 @@ func (d *Dispatcher) DispatchExpand(ctx context.Context, req *v1.DispatchExpandR
 	if err != nil {
 		return &v1.DispatchExpandResponse{Metadata: &v1.ResponseMeta{DispatchCount: 1}}, err
 	}
+
+	// Same rationale as DispatchCheck above: an expand tree whose nodes carry a caveat expression
+	// referencing context can't be safely memoized against future callers with different context.
+	if !expandResponseReferencesContext(v) {
+		d.expandCache.put(keyString, atRevision, v)
+	}
+
 	return v, err
 }
 
+// requestRevision returns the serialized AtRevision carried on a request's metadata, or the
+// empty string if the request has no metadata.
+func requestRevision(meta *v1.ResolverMeta) string {
+	if meta == nil {
+		return ""
+	}
+	return meta.AtRevision
+}
+
+// checkResponseReferencesContext reports whether resp has any caveated member whose caveat
+// carries its own partial context, meaning its evaluation depends on context that may vary
+// between otherwise-identical requests.
+func checkResponseReferencesContext(resp *v1.DispatchCheckResponse) bool {
+	if resp == nil {
+		return false
+	}
+
+	for _, result := range resp.ResultsByResourceId {
+		if caveatExpressionReferencesContext(result.Expression) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expandResponseReferencesContext reports whether any node in resp's expansion tree carries a
+// caveat expression that references context, meaning its evaluation depends on context that may
+// vary between otherwise-identical requests.
+func expandResponseReferencesContext(resp *v1.DispatchExpandResponse) bool {
+	if resp == nil {
+		return false
+	}
+	return expandTreeNodeReferencesContext(resp.TreeNode)
+}
+
+func expandTreeNodeReferencesContext(node *v1.RelationTupleTreeNode) bool {
+	if node == nil {
+		return false
+	}
+
+	if caveatExpressionReferencesContext(node.CaveatExpression) {
+		return true
+	}
+
+	if intermediate := node.GetIntermediateNode(); intermediate != nil {
+		for _, child := range intermediate.ChildNodes {
+			if expandTreeNodeReferencesContext(child) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func caveatExpressionReferencesContext(expr *v1.CaveatExpression) bool {
+	if expr == nil {
+		return false
+	}
+
+	switch t := expr.OperationOrCaveat.(type) {
+	case *v1.CaveatExpression_Caveat:
+		return t.Caveat.Context != nil && len(t.Caveat.Context.Fields) > 0
+	case *v1.CaveatExpression_Operation:
+		for _, child := range t.Operation.Children {
+			if caveatExpressionReferencesContext(child) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func (d *Dispatcher) DispatchReachableResources(req *v1.DispatchReachableResourcesRequest, stream dispatch.ReachableResourcesStream) error {
-	return d.delegate.DispatchReachableResources(req, stream)
+	key, err := d.keyHandler.ReachableResourcesDispatchKey(stream.Context(), req)
+	if err != nil {
+		return status.Error(codes.Internal, "unexpected DispatchReachableResources error")
+	}
+
+	return doStreamingSingleFlight(
+		"DispatchReachableResources",
+		&d.reachableResourcesMu,
+		d.reachableResourcesInFlight,
+		hex.EncodeToString(key),
+		d.maxSharedStreamHistory,
+		stream,
+		func(innerStream dispatch.ReachableResourcesStream) error {
+			return d.delegate.DispatchReachableResources(req, innerStream)
+		},
+	)
 }
 
 func (d *Dispatcher) DispatchLookupResources(req *v1.DispatchLookupResourcesRequest, stream dispatch.LookupResourcesStream) error {
-	return d.delegate.DispatchLookupResources(req, stream)
+	key, err := d.keyHandler.LookupResourcesDispatchKey(stream.Context(), req)
+	if err != nil {
+		return status.Error(codes.Internal, "unexpected DispatchLookupResources error")
+	}
+
+	return doStreamingSingleFlight(
+		"DispatchLookupResources",
+		&d.lookupResourcesMu,
+		d.lookupResourcesInFlight,
+		hex.EncodeToString(key),
+		d.maxSharedStreamHistory,
+		stream,
+		func(innerStream dispatch.LookupResourcesStream) error {
+			return d.delegate.DispatchLookupResources(req, innerStream)
+		},
+	)
 }
 
 func (d *Dispatcher) DispatchLookupSubjects(req *v1.DispatchLookupSubjectsRequest, stream dispatch.LookupSubjectsStream) error {
-	return d.delegate.DispatchLookupSubjects(req, stream)
+	key, err := d.keyHandler.LookupSubjectsDispatchKey(stream.Context(), req)
+	if err != nil {
+		return status.Error(codes.Internal, "unexpected DispatchLookupSubjects error")
+	}
+
+	return doStreamingSingleFlight(
+		"DispatchLookupSubjects",
+		&d.lookupSubjectsMu,
+		d.lookupSubjectsInFlight,
+		hex.EncodeToString(key),
+		d.maxSharedStreamHistory,
+		stream,
+		func(innerStream dispatch.LookupSubjectsStream) error {
+			return d.delegate.DispatchLookupSubjects(req, innerStream)
+		},
+	)
 }
 
 func (d *Dispatcher) Close() error                    { return d.delegate.Close() }
 func (d *Dispatcher) ReadyState() dispatch.ReadyState { return d.delegate.ReadyState() }
+
+// doStreamingSingleFlight coalesces concurrent streaming dispatch calls that share the same
+// dispatch key into a single call to the delegate, fanning out every published message to each
+// subscriber. The first caller in for a given key drives the delegate call; every other caller
+// for that key merely subscribes to the results as they're produced. maxHistory bounds how many
+// messages the share retains to backfill late joiners; a caller arriving after a share has sealed
+// runs the delegate on its own instead of joining.
+func doStreamingSingleFlight[Resp any](
+	method string,
+	mu *sync.Mutex,
+	inFlight map[string]*streamShare[Resp],
+	keyString string,
+	maxHistory int,
+	callerStream dispatch.Stream[Resp],
+	callDelegate func(dispatch.Stream[Resp]) error,
+) error {
+	mu.Lock()
+	share, isShared := inFlight[keyString]
+	if !isShared {
+		share = newStreamShare[Resp](maxHistory)
+		inFlight[keyString] = share
+	}
+	mu.Unlock()
+
+	sub, joined := share.subscribe(callerStream.Context())
+	if !joined {
+		// The share sealed, either because its replay history hit maxHistory, so it can no longer
+		// safely backfill a new subscriber, or because its last subscriber already left and it is
+		// being torn down. Run the delegate directly for this caller alone rather than silently
+		// dropping the messages published before it could have joined.
+		singleFlightCount.WithLabelValues(method, "false").Inc()
+		return callDelegate(callerStream)
+	}
+
+	singleFlightCount.WithLabelValues(method, strconv.FormatBool(isShared)).Inc()
+
+	defer func() {
+		if share.unsubscribe(sub) {
+			// share just lost its last subscriber. Remove it from inFlight immediately, under
+			// the same lock a new caller checks, so a new caller with the same key always starts
+			// a fresh share/delegate call instead of racing to attach to this one while it's
+			// being torn down (the producer goroutine below may not unwind for a while yet).
+			removeShareLocked(mu, inFlight, keyString, share)
+		}
+	}()
+
+	if !isShared {
+		go func() {
+			err := callDelegate(&sharedProducerStream[Resp]{ctx: share.ctx, share: share})
+
+			removeShareLocked(mu, inFlight, keyString, share)
+
+			singleFlightStreamCompletedCount.WithLabelValues(method, strconv.FormatBool(err != nil)).Inc()
+			share.finish(err)
+		}()
+	}
+
+	for {
+		select {
+		case resp, ok := <-sub.ch:
+			if !ok {
+				return sub.closeErr
+			}
+			if err := callerStream.Publish(resp); err != nil {
+				return err
+			}
+		case <-callerStream.Context().Done():
+			return callerStream.Context().Err()
+		}
+	}
+}
+
+// removeShareLocked removes share from inFlight under mu, but only if it's still the entry
+// registered for keyString. A new share may already have taken its place by the time this runs
+// (e.g. unsubscribe and the producer's own completion racing to remove the same share), and this
+// must not delete that newer share out from under its own subscribers.
+func removeShareLocked[Resp any](mu *sync.Mutex, inFlight map[string]*streamShare[Resp], keyString string, share *streamShare[Resp]) {
+	mu.Lock()
+	defer mu.Unlock()
+	if inFlight[keyString] == share {
+		delete(inFlight, keyString)
+	}
+}
+
+// sharedProducerStream adapts a streamShare into the dispatch.Stream interface expected by the
+// delegate, so the delegate is unaware it is feeding more than one subscriber.
+type sharedProducerStream[Resp any] struct {
+	ctx   context.Context
+	share *streamShare[Resp]
+}
+
+func (s *sharedProducerStream[Resp]) Context() context.Context { return s.ctx }
+
+func (s *sharedProducerStream[Resp]) Publish(resp Resp) error {
+	s.share.publish(resp)
+	return nil
+}
+
+// streamShare coordinates a single upstream streaming dispatch call being shared by one or more
+// concurrent subscribers with the same dispatch key. Messages already published are replayed to
+// subscribers that join mid-stream; a subscriber that cannot keep up or whose own context is
+// canceled is dropped from the fan-out without affecting the shared producer or any other
+// subscriber. Once history reaches maxHistory, the share seals and stops accepting new
+// subscribers, bounding how much of a large result set it holds in memory on their behalf.
+type streamShare[Resp any] struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	maxHistory int
+
+	mu          sync.Mutex
+	subscribers map[*streamSubscription[Resp]]struct{}
+	history     []Resp
+	sealed      bool
+}
+
+func newStreamShare[Resp any](maxHistory int) *streamShare[Resp] {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &streamShare[Resp]{
+		ctx:         ctx,
+		cancel:      cancel,
+		maxHistory:  maxHistory,
+		subscribers: make(map[*streamSubscription[Resp]]struct{}),
+	}
+}
+
+type streamSubscription[Resp any] struct {
+	ctx      context.Context
+	ch       chan Resp
+	closeErr error
+}
+
+// subscribe registers a new subscriber for the share, replaying any messages already published
+// before the subscriber joined. It reports false if the share has sealed (its history reached
+// maxHistory, or its last subscriber has left), in which case no subscription was created and the
+// caller must not treat the share as shared.
+func (s *streamShare[Resp]) subscribe(ctx context.Context) (*streamSubscription[Resp], bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sealed {
+		return nil, false
+	}
+
+	// Size the buffer to fit the full backlog up front so replaying history below can never
+	// block while holding the lock; subscriberBufferSize of additional headroom is left for
+	// messages published after the subscriber joins.
+	sub := &streamSubscription[Resp]{ctx: ctx, ch: make(chan Resp, len(s.history)+subscriberBufferSize)}
+	for _, resp := range s.history {
+		sub.ch <- resp
+	}
+	s.subscribers[sub] = struct{}{}
+	return sub, true
+}
+
+// unsubscribe removes a subscriber from the share and reports whether it was the last one. If so,
+// the upstream delegate call is canceled, since no one remains to consume its results.
+func (s *streamShare[Resp]) unsubscribe(sub *streamSubscription[Resp]) bool {
+	s.mu.Lock()
+	delete(s.subscribers, sub)
+	remaining := len(s.subscribers)
+	if remaining == 0 {
+		// Seal before canceling, so a caller that still finds this share in inFlight before it is
+		// removed runs its own delegate call instead of attaching to a canceled share.
+		s.sealed = true
+	}
+	s.mu.Unlock()
+
+	if remaining == 0 {
+		s.cancel()
+	}
+
+	return remaining == 0
+}
+
+// publish fans a message out to every current subscriber. A subscriber whose context has been
+// canceled, or whose buffer is full, is dropped rather than allowed to stall the producer.
+func (s *streamShare[Resp]) publish(resp Resp) {
+	s.mu.Lock()
+	// Once sealed, no subscriber can join and read the history again, so it stops growing.
+	if !s.sealed {
+		s.history = append(s.history, resp)
+		if s.maxHistory > 0 && len(s.history) >= s.maxHistory {
+			// Seal so no further subscriber can join expecting a full, accurate replay; a late
+			// arrival past this point runs the delegate on its own instead. Subscribers already
+			// attached are unaffected and keep receiving messages as they're published.
+			s.sealed = true
+		}
+	}
+	subs := make([]*streamSubscription[Resp], 0, len(s.subscribers))
+	for sub := range s.subscribers {
+		subs = append(subs, sub)
+	}
+	s.mu.Unlock()
+
+	for _, sub := range subs {
+		select {
+		case sub.ch <- resp:
+		case <-sub.ctx.Done():
+			s.dropSubscriber(sub, sub.ctx.Err())
+		default:
+			s.dropSubscriber(sub, errSubscriberTooSlow)
+		}
+	}
+}
+
+func (s *streamShare[Resp]) dropSubscriber(sub *streamSubscription[Resp], err error) {
+	s.mu.Lock()
+	if _, ok := s.subscribers[sub]; !ok {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.subscribers, sub)
+	s.mu.Unlock()
+
+	sub.closeErr = err
+	close(sub.ch)
+}
+
+// finish marks the shared producer as complete, delivering the final error (if any) to every
+// remaining subscriber.
+func (s *streamShare[Resp]) finish(err error) {
+	s.mu.Lock()
+	subs := make([]*streamSubscription[Resp], 0, len(s.subscribers))
+	for sub := range s.subscribers {
+		subs = append(subs, sub)
+	}
+	s.subscribers = make(map[*streamSubscription[Resp]]struct{})
+	s.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.closeErr = err
+		close(sub.ch)
+	}
+}
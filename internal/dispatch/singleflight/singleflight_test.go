@@ -0,0 +1,539 @@
+package singleflight
+
+import (
+	"context"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/authzed/spicedb/internal/dispatch"
+	"github.com/authzed/spicedb/pkg/datastore"
+	v1 "github.com/authzed/spicedb/pkg/proto/dispatch/v1"
+)
+
+// waitForReachableResourcesSubscribers blocks until exactly count goroutines are subscribed to
+// the shared stream for key, so tests can deterministically drive the fake delegate forward
+// without racing a subscriber that hasn't yet registered.
+func waitForReachableResourcesSubscribers(t *testing.T, d *Dispatcher, key []byte, count int) {
+	t.Helper()
+
+	keyString := hex.EncodeToString(key)
+	require.Eventually(t, func() bool {
+		d.reachableResourcesMu.Lock()
+		share, ok := d.reachableResourcesInFlight[keyString]
+		d.reachableResourcesMu.Unlock()
+		if !ok {
+			return false
+		}
+
+		share.mu.Lock()
+		defer share.mu.Unlock()
+		return len(share.subscribers) == count
+	}, time.Second, time.Millisecond)
+}
+
+// fakeReachableResourcesDelegate publishes a fixed sequence of responses, pausing after each one
+// until told to continue, so tests can control exactly how far a shared stream has progressed
+// before asserting on subscriber behavior.
+type fakeReachableResourcesDelegate struct {
+	fakeDispatcher
+
+	advance chan struct{}
+	started chan struct{}
+}
+
+func newFakeReachableResourcesDelegate() *fakeReachableResourcesDelegate {
+	return &fakeReachableResourcesDelegate{
+		advance: make(chan struct{}),
+		started: make(chan struct{}, 1),
+	}
+}
+
+func (f *fakeReachableResourcesDelegate) DispatchReachableResources(
+	req *v1.DispatchReachableResourcesRequest,
+	stream dispatch.ReachableResourcesStream,
+) error {
+	f.started <- struct{}{}
+
+	for i := 0; i < 3; i++ {
+		<-f.advance
+		if err := stream.Publish(&v1.DispatchReachableResourcesResponse{
+			Resource: &v1.PossibleResource{ResourceId: string(rune('a' + i))},
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fakeDispatcher implements dispatch.Dispatcher with no-op defaults; tests embed it and override
+// only the methods under test.
+type fakeDispatcher struct{}
+
+func (fakeDispatcher) DispatchCheck(context.Context, *v1.DispatchCheckRequest) (*v1.DispatchCheckResponse, error) {
+	return nil, nil
+}
+
+func (fakeDispatcher) DispatchExpand(context.Context, *v1.DispatchExpandRequest) (*v1.DispatchExpandResponse, error) {
+	return nil, nil
+}
+
+func (fakeDispatcher) DispatchReachableResources(*v1.DispatchReachableResourcesRequest, dispatch.ReachableResourcesStream) error {
+	return nil
+}
+
+func (fakeDispatcher) DispatchLookupResources(*v1.DispatchLookupResourcesRequest, dispatch.LookupResourcesStream) error {
+	return nil
+}
+
+func (fakeDispatcher) DispatchLookupSubjects(*v1.DispatchLookupSubjectsRequest, dispatch.LookupSubjectsStream) error {
+	return nil
+}
+
+func (fakeDispatcher) Close() error { return nil }
+
+func (fakeDispatcher) ReadyState() dispatch.ReadyState {
+	return dispatch.ReadyState{IsReady: true}
+}
+
+// fakeKeyHandler derives streaming dispatch keys from the request's ResourceRelation/SubjectRelation
+// alone, so differently-shaped requests can be made to collide on purpose in tests. Every Check
+// request shares one fixed key, as does every Expand request.
+type fakeKeyHandler struct{}
+
+func (fakeKeyHandler) CheckDispatchKey(context.Context, *v1.DispatchCheckRequest) ([]byte, error) {
+	return []byte("check"), nil
+}
+
+func (fakeKeyHandler) ExpandDispatchKey(context.Context, *v1.DispatchExpandRequest) ([]byte, error) {
+	return []byte("expand"), nil
+}
+
+func (fakeKeyHandler) ReachableResourcesDispatchKey(_ context.Context, req *v1.DispatchReachableResourcesRequest) ([]byte, error) {
+	return []byte(req.ResourceRelation.Namespace), nil
+}
+
+func (fakeKeyHandler) LookupResourcesDispatchKey(_ context.Context, req *v1.DispatchLookupResourcesRequest) ([]byte, error) {
+	return []byte(req.ResourceRelation.Namespace), nil
+}
+
+func (fakeKeyHandler) LookupSubjectsDispatchKey(_ context.Context, req *v1.DispatchLookupSubjectsRequest) ([]byte, error) {
+	return []byte(req.SubjectRelation.Namespace), nil
+}
+
+// collectingStream is a dispatch.Stream that records every published response and satisfies
+// cancellation via its context.
+type collectingStream struct {
+	ctx context.Context
+
+	mu        sync.Mutex
+	responses []*v1.DispatchReachableResourcesResponse
+}
+
+func (s *collectingStream) Context() context.Context { return s.ctx }
+
+func (s *collectingStream) Publish(resp *v1.DispatchReachableResourcesResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.responses = append(s.responses, resp)
+	return nil
+}
+
+func (s *collectingStream) collected() []*v1.DispatchReachableResourcesResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]*v1.DispatchReachableResourcesResponse, len(s.responses))
+	copy(out, s.responses)
+	return out
+}
+
+// countingCheckDelegate returns a fixed response to every DispatchCheck call, recording how many
+// times it was actually invoked so tests can assert on cache/singleflight hit behavior.
+type countingCheckDelegate struct {
+	fakeDispatcher
+
+	mu    sync.Mutex
+	calls int
+	resp  *v1.DispatchCheckResponse
+}
+
+func (d *countingCheckDelegate) DispatchCheck(context.Context, *v1.DispatchCheckRequest) (*v1.DispatchCheckResponse, error) {
+	d.mu.Lock()
+	d.calls++
+	d.mu.Unlock()
+	return d.resp, nil
+}
+
+func (d *countingCheckDelegate) callCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.calls
+}
+
+func fakeRevisionParser(serialized string) (datastore.Revision, error) {
+	if serialized == "" {
+		return fakeRevision(0), nil
+	}
+	n, err := strconv.ParseInt(serialized, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return fakeRevision(n), nil
+}
+
+func TestResultCacheServesSecondIdenticalCheckFromCache(t *testing.T) {
+	delegate := &countingCheckDelegate{resp: &v1.DispatchCheckResponse{}}
+	d := New(delegate, fakeKeyHandler{}, WithResultCache(10, time.Minute, fakeRevisionParser))
+
+	req := &v1.DispatchCheckRequest{Metadata: &v1.ResolverMeta{AtRevision: "1"}}
+
+	_, err := d.DispatchCheck(context.Background(), req)
+	require.NoError(t, err)
+
+	_, err = d.DispatchCheck(context.Background(), req)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, delegate.callCount(), "second identical request should have been served from the result cache")
+}
+
+func TestResultCacheMissesOnceRevisionAdvances(t *testing.T) {
+	delegate := &countingCheckDelegate{resp: &v1.DispatchCheckResponse{}}
+	d := New(delegate, fakeKeyHandler{}, WithResultCache(10, time.Minute, fakeRevisionParser))
+
+	_, err := d.DispatchCheck(context.Background(), &v1.DispatchCheckRequest{Metadata: &v1.ResolverMeta{AtRevision: "1"}})
+	require.NoError(t, err)
+
+	_, err = d.DispatchCheck(context.Background(), &v1.DispatchCheckRequest{Metadata: &v1.ResolverMeta{AtRevision: "2"}})
+	require.NoError(t, err)
+
+	require.Equal(t, 2, delegate.callCount(), "a request at a newer revision must not be served from the result cache")
+}
+
+func TestResultCacheMissesOnOlderRevisionThanCached(t *testing.T) {
+	delegate := &countingCheckDelegate{resp: &v1.DispatchCheckResponse{}}
+	d := New(delegate, fakeKeyHandler{}, WithResultCache(10, time.Minute, fakeRevisionParser))
+
+	_, err := d.DispatchCheck(context.Background(), &v1.DispatchCheckRequest{Metadata: &v1.ResolverMeta{AtRevision: "2"}})
+	require.NoError(t, err)
+
+	_, err = d.DispatchCheck(context.Background(), &v1.DispatchCheckRequest{Metadata: &v1.ResolverMeta{AtRevision: "1"}})
+	require.NoError(t, err)
+
+	require.Equal(t, 2, delegate.callCount(), "a request pinned to an older revision than the cached entry must not be served from the result cache")
+}
+
+func TestResultCacheSkippedWithoutOption(t *testing.T) {
+	delegate := &countingCheckDelegate{resp: &v1.DispatchCheckResponse{}}
+	d := New(delegate, fakeKeyHandler{})
+
+	req := &v1.DispatchCheckRequest{Metadata: &v1.ResolverMeta{AtRevision: "1"}}
+
+	_, err := d.DispatchCheck(context.Background(), req)
+	require.NoError(t, err)
+
+	_, err = d.DispatchCheck(context.Background(), req)
+	require.NoError(t, err)
+
+	require.Equal(t, 2, delegate.callCount(), "without WithResultCache, behavior must be unchanged and every call reaches the delegate")
+}
+
+func TestReachableResourcesLateSubscriberJoinsMidStream(t *testing.T) {
+	delegate := newFakeReachableResourcesDelegate()
+	d := New(delegate, fakeKeyHandler{}).(*Dispatcher)
+
+	req := &v1.DispatchReachableResourcesRequest{
+		ResourceRelation: &v1.RelationReference{Namespace: "document"},
+	}
+
+	first := &collectingStream{ctx: context.Background()}
+	var firstErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		firstErr = d.DispatchReachableResources(req, first)
+	}()
+
+	<-delegate.started
+	delegate.advance <- struct{}{} // publish response 0
+
+	// Give the first response time to land before the late subscriber joins, so it genuinely
+	// joins mid-stream rather than racing the first publish.
+	require.Eventually(t, func() bool {
+		return len(first.collected()) == 1
+	}, time.Second, time.Millisecond)
+
+	late := &collectingStream{ctx: context.Background()}
+	var lateErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		lateErr = d.DispatchReachableResources(req, late)
+	}()
+
+	// Don't drive the producer any further until the late subscriber has actually registered;
+	// otherwise the producer could race ahead and finish before the late subscriber joins,
+	// leaving nothing left to share with.
+	waitForReachableResourcesSubscribers(t, d, []byte(req.ResourceRelation.Namespace), 2)
+
+	delegate.advance <- struct{}{} // publish response 1
+	delegate.advance <- struct{}{} // publish response 2
+
+	wg.Wait()
+
+	require.NoError(t, firstErr)
+	require.NoError(t, lateErr)
+	require.Len(t, first.collected(), 3)
+
+	// The late subscriber must have been backfilled with the message it missed, plus everything
+	// published afterward.
+	require.Len(t, late.collected(), 3)
+}
+
+func TestReachableResourcesCanceledSubscriberDoesNotStallOthers(t *testing.T) {
+	delegate := newFakeReachableResourcesDelegate()
+	d := New(delegate, fakeKeyHandler{}).(*Dispatcher)
+
+	req := &v1.DispatchReachableResourcesRequest{
+		ResourceRelation: &v1.RelationReference{Namespace: "document"},
+	}
+
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	canceled := &collectingStream{ctx: cancelCtx}
+	survivor := &collectingStream{ctx: context.Background()}
+
+	var canceledErr, survivorErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		canceledErr = d.DispatchReachableResources(req, canceled)
+	}()
+	go func() {
+		defer wg.Done()
+		survivorErr = d.DispatchReachableResources(req, survivor)
+	}()
+
+	waitForReachableResourcesSubscribers(t, d, []byte(req.ResourceRelation.Namespace), 2)
+
+	<-delegate.started
+	delegate.advance <- struct{}{} // publish response 0
+
+	require.Eventually(t, func() bool {
+		return len(survivor.collected()) == 1
+	}, time.Second, time.Millisecond)
+
+	cancel()
+
+	delegate.advance <- struct{}{} // publish response 1
+	delegate.advance <- struct{}{} // publish response 2
+
+	wg.Wait()
+
+	require.ErrorIs(t, canceledErr, context.Canceled)
+	require.NoError(t, survivorErr)
+	require.Len(t, survivor.collected(), 3)
+}
+
+// racingReachableResourcesDelegate blocks each call to DispatchReachableResources until the test
+// explicitly releases that specific call by index, so a test can hold one call open while driving
+// a second, independent call through the dispatcher.
+type racingReachableResourcesDelegate struct {
+	fakeDispatcher
+
+	started chan int
+
+	mu      sync.Mutex
+	calls   int
+	release map[int]chan struct{}
+}
+
+func newRacingReachableResourcesDelegate() *racingReachableResourcesDelegate {
+	return &racingReachableResourcesDelegate{
+		started: make(chan int, 8),
+		release: make(map[int]chan struct{}),
+	}
+}
+
+func (d *racingReachableResourcesDelegate) DispatchReachableResources(
+	req *v1.DispatchReachableResourcesRequest,
+	stream dispatch.ReachableResourcesStream,
+) error {
+	d.mu.Lock()
+	d.calls++
+	idx := d.calls
+	rel := make(chan struct{})
+	d.release[idx] = rel
+	d.mu.Unlock()
+
+	d.started <- idx
+	<-rel
+
+	return stream.Publish(&v1.DispatchReachableResourcesResponse{
+		Resource: &v1.PossibleResource{ResourceId: string(rune('a' + idx))},
+	})
+}
+
+func (d *racingReachableResourcesDelegate) releaseCall(idx int) {
+	d.mu.Lock()
+	rel := d.release[idx]
+	d.mu.Unlock()
+	close(rel)
+}
+
+// TestReachableResourcesNewCallerDoesNotAttachToDyingShare guards against a race where a share's
+// last subscriber leaves (canceling the share) before its still-running producer goroutine has
+// unwound and removed the share from inFlight. A new caller arriving for the same key in that
+// window must start a fresh share/delegate call of its own rather than subscribing to the dying
+// share and inheriting its spurious cancellation error.
+func TestReachableResourcesNewCallerDoesNotAttachToDyingShare(t *testing.T) {
+	delegate := newRacingReachableResourcesDelegate()
+	d := New(delegate, fakeKeyHandler{}).(*Dispatcher)
+
+	req := &v1.DispatchReachableResourcesRequest{
+		ResourceRelation: &v1.RelationReference{Namespace: "document"},
+	}
+
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	first := &collectingStream{ctx: firstCtx}
+	var firstErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		firstErr = d.DispatchReachableResources(req, first)
+	}()
+
+	firstIdx := <-delegate.started
+
+	// Cancel the only subscriber of the first call. This cancels the share, but the first call's
+	// producer goroutine is still blocked below and hasn't reached its own teardown yet.
+	cancelFirst()
+
+	key := hex.EncodeToString([]byte(req.ResourceRelation.Namespace))
+	require.Eventually(t, func() bool {
+		d.reachableResourcesMu.Lock()
+		_, ok := d.reachableResourcesInFlight[key]
+		d.reachableResourcesMu.Unlock()
+		return !ok
+	}, time.Second, time.Millisecond, "share must be removed from inFlight as soon as its last subscriber leaves")
+
+	second := &collectingStream{ctx: context.Background()}
+	var secondErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		secondErr = d.DispatchReachableResources(req, second)
+	}()
+
+	secondIdx := <-delegate.started
+	require.NotEqual(t, firstIdx, secondIdx, "second caller must trigger a fresh delegate call instead of attaching to the dying share")
+
+	delegate.releaseCall(secondIdx)
+	delegate.releaseCall(firstIdx)
+	wg.Wait()
+
+	require.ErrorIs(t, firstErr, context.Canceled)
+	require.NoError(t, secondErr)
+	require.Len(t, second.collected(), 1)
+}
+
+// historyCapDelegate publishes a single response as soon as it's called, then blocks until the
+// test releases that specific call by index, so a test can deterministically observe a share's
+// history right after it crosses a small maxHistory cap.
+type historyCapDelegate struct {
+	fakeDispatcher
+
+	started chan int
+
+	mu      sync.Mutex
+	calls   int
+	release map[int]chan struct{}
+}
+
+func newHistoryCapDelegate() *historyCapDelegate {
+	return &historyCapDelegate{
+		started: make(chan int, 8),
+		release: make(map[int]chan struct{}),
+	}
+}
+
+func (d *historyCapDelegate) DispatchReachableResources(
+	req *v1.DispatchReachableResourcesRequest,
+	stream dispatch.ReachableResourcesStream,
+) error {
+	d.mu.Lock()
+	d.calls++
+	idx := d.calls
+	rel := make(chan struct{})
+	d.release[idx] = rel
+	d.mu.Unlock()
+
+	if err := stream.Publish(&v1.DispatchReachableResourcesResponse{
+		Resource: &v1.PossibleResource{ResourceId: string(rune('a' + idx))},
+	}); err != nil {
+		return err
+	}
+
+	d.started <- idx
+	<-rel
+
+	return nil
+}
+
+func (d *historyCapDelegate) releaseCall(idx int) {
+	d.mu.Lock()
+	rel := d.release[idx]
+	d.mu.Unlock()
+	close(rel)
+}
+
+// TestReachableResourcesSealsSharedStreamOnceHistoryCapReached verifies that once a shared stream
+// publishes maxSharedStreamHistory messages, it stops accepting new subscribers, so a late joiner
+// runs its own independent delegate call instead of being backfilled from truncated history.
+func TestReachableResourcesSealsSharedStreamOnceHistoryCapReached(t *testing.T) {
+	delegate := newHistoryCapDelegate()
+	d := New(delegate, fakeKeyHandler{}, WithMaxSharedStreamHistory(1)).(*Dispatcher)
+
+	req := &v1.DispatchReachableResourcesRequest{
+		ResourceRelation: &v1.RelationReference{Namespace: "document"},
+	}
+
+	first := &collectingStream{ctx: context.Background()}
+	var firstErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		firstErr = d.DispatchReachableResources(req, first)
+	}()
+
+	// By the time this fires, the delegate has already published its one message, which brings
+	// the share's history to the configured cap and seals it.
+	firstIdx := <-delegate.started
+
+	second := &collectingStream{ctx: context.Background()}
+	var secondErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		secondErr = d.DispatchReachableResources(req, second)
+	}()
+
+	secondIdx := <-delegate.started
+	require.NotEqual(t, firstIdx, secondIdx, "a late joiner arriving after the history cap is reached must trigger its own delegate call")
+
+	delegate.releaseCall(firstIdx)
+	delegate.releaseCall(secondIdx)
+	wg.Wait()
+
+	require.NoError(t, firstErr)
+	require.NoError(t, secondErr)
+	require.Len(t, first.collected(), 1)
+	require.Len(t, second.collected(), 1)
+}
@@ -0,0 +1,263 @@
+package graph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	v1 "github.com/authzed/spicedb/pkg/proto/dispatch/v1"
+)
+
+// Simplify normalizes a CaveatExpression tree, such as the ones built up by repeated calls to
+// MembershipSet's UnionWith, IntersectWith, and Subtract. Left unnormalized, those trees grow
+// unbounded with every additional combination performed, even when the combination is logically
+// redundant.
+//
+// Normalization: flattens associative AND/OR chains into n-ary nodes, pushes NOTs down to the
+// leaves via De Morgan's laws (canceling double negation along the way), deduplicates
+// structurally-equal children, short-circuits contradictions (AND(x, ¬x) -> false,
+// OR(x, ¬x) -> true), and applies boolean absorption (A ∧ (A ∨ B) -> A, A ∨ (A ∧ B) -> A).
+//
+// A nil result means the expression is statically true. A non-nil result for which isAlwaysFalse
+// returns true means the expression is statically false; callers that need a real false value
+// (rather than simply discarding the member) should check for it explicitly.
+func Simplify(expr *v1.CaveatExpression) *v1.CaveatExpression {
+	return simplify(expr)
+}
+
+func simplify(expr *v1.CaveatExpression) *v1.CaveatExpression {
+	if expr == nil {
+		return nil
+	}
+
+	op, ok := expr.OperationOrCaveat.(*v1.CaveatExpression_Operation)
+	if !ok {
+		return expr
+	}
+
+	switch op.Operation.Op {
+	case v1.CaveatOperation_NOT:
+		return simplifyNot(op.Operation.Children[0])
+	case v1.CaveatOperation_AND, v1.CaveatOperation_OR:
+		return simplifyAssociative(op.Operation.Children, op.Operation.Op)
+	default:
+		return expr
+	}
+}
+
+// simplifyNot simplifies child and then negates the result, pushing the negation down to the
+// leaves rather than leaving it wrapped around an operation.
+func simplifyNot(child *v1.CaveatExpression) *v1.CaveatExpression {
+	simplified := simplify(child)
+
+	if simplified == nil {
+		return falseExpr()
+	}
+	if isAlwaysFalse(simplified) {
+		return nil
+	}
+
+	if op, ok := simplified.OperationOrCaveat.(*v1.CaveatExpression_Operation); ok {
+		switch op.Operation.Op {
+		case v1.CaveatOperation_NOT:
+			return op.Operation.Children[0]
+		case v1.CaveatOperation_AND, v1.CaveatOperation_OR:
+			negatedChildren := make([]*v1.CaveatExpression, 0, len(op.Operation.Children))
+			for _, child := range op.Operation.Children {
+				negatedChildren = append(negatedChildren, simplifyNot(child))
+			}
+			return simplifyAssociative(negatedChildren, flip(op.Operation.Op))
+		}
+	}
+
+	return negate(simplified)
+}
+
+// simplifyAssociative simplifies and flattens the children of an AND/OR node, applying
+// deduplication, contradiction short-circuiting, and absorption.
+func simplifyAssociative(children []*v1.CaveatExpression, kind v1.CaveatOperation_Operation) *v1.CaveatExpression {
+	flattened := make([]*v1.CaveatExpression, 0, len(children))
+	for _, child := range children {
+		simplified := simplify(child)
+
+		if simplified == nil {
+			if kind == v1.CaveatOperation_OR {
+				return nil // OR(true, ...) is always true
+			}
+			continue // AND(true, ...) drops the true
+		}
+		if isAlwaysFalse(simplified) {
+			if kind == v1.CaveatOperation_AND {
+				return falseExpr() // AND(false, ...) is always false
+			}
+			continue // OR(false, ...) drops the false
+		}
+
+		if childOp, ok := simplified.OperationOrCaveat.(*v1.CaveatExpression_Operation); ok && childOp.Operation.Op == kind {
+			flattened = append(flattened, childOp.Operation.Children...)
+			continue
+		}
+
+		flattened = append(flattened, simplified)
+	}
+
+	deduped := dedupeAndCanonicalize(flattened)
+
+	if hasComplementaryPair(deduped) {
+		if kind == v1.CaveatOperation_AND {
+			return falseExpr()
+		}
+		return nil
+	}
+
+	deduped = absorb(deduped, kind)
+
+	switch len(deduped) {
+	case 0:
+		if kind == v1.CaveatOperation_AND {
+			return nil // vacuous AND is true
+		}
+		return falseExpr() // vacuous OR is false
+	case 1:
+		return deduped[0]
+	default:
+		return &v1.CaveatExpression{
+			OperationOrCaveat: &v1.CaveatExpression_Operation{
+				Operation: &v1.CaveatOperation{Op: kind, Children: deduped},
+			},
+		}
+	}
+}
+
+// dedupeAndCanonicalize removes structurally-equal children and orders the survivors by their
+// structural hash, so that logically-equivalent expressions built from children in a different
+// order normalize to the same tree.
+func dedupeAndCanonicalize(children []*v1.CaveatExpression) []*v1.CaveatExpression {
+	byHash := make(map[string]*v1.CaveatExpression, len(children))
+	for _, child := range children {
+		byHash[hashExpr(child)] = child
+	}
+
+	hashes := make([]string, 0, len(byHash))
+	for h := range byHash {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+
+	out := make([]*v1.CaveatExpression, 0, len(hashes))
+	for _, h := range hashes {
+		out = append(out, byHash[h])
+	}
+	return out
+}
+
+// hasComplementaryPair returns true if children contains both some expression x and its negation.
+func hasComplementaryPair(children []*v1.CaveatExpression) bool {
+	present := make(map[string]struct{}, len(children))
+	for _, child := range children {
+		present[hashExpr(child)] = struct{}{}
+	}
+
+	for _, child := range children {
+		op, ok := child.OperationOrCaveat.(*v1.CaveatExpression_Operation)
+		if !ok || op.Operation.Op != v1.CaveatOperation_NOT {
+			continue
+		}
+		if _, ok := present[hashExpr(op.Operation.Children[0])]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// absorb applies boolean absorption: a child of the complementary operation that itself contains
+// one of this node's other children is redundant and can be dropped, e.g. A ∧ (A ∨ B) -> A.
+func absorb(children []*v1.CaveatExpression, kind v1.CaveatOperation_Operation) []*v1.CaveatExpression {
+	complementKind := flip(kind)
+
+	top := make(map[string]struct{}, len(children))
+	for _, child := range children {
+		top[hashExpr(child)] = struct{}{}
+	}
+
+	out := make([]*v1.CaveatExpression, 0, len(children))
+	for _, child := range children {
+		if op, ok := child.OperationOrCaveat.(*v1.CaveatExpression_Operation); ok && op.Operation.Op == complementKind {
+			absorbed := false
+			for _, grandchild := range op.Operation.Children {
+				if _, ok := top[hashExpr(grandchild)]; ok {
+					absorbed = true
+					break
+				}
+			}
+			if absorbed {
+				continue
+			}
+		}
+		out = append(out, child)
+	}
+	return out
+}
+
+// falseExpr returns the sentinel CaveatExpression used to represent a statically-false
+// expression. The proto schema has no literal boolean leaf, but it doesn't need one: a nil
+// CaveatExpression already means "statically true" by convention throughout this package (see
+// MembershipSet.addMember), and an OR operation with no children - the identity element of OR -
+// is its dual, used here as "statically false".
+func falseExpr() *v1.CaveatExpression {
+	return &v1.CaveatExpression{
+		OperationOrCaveat: &v1.CaveatExpression_Operation{
+			Operation: &v1.CaveatOperation{Op: v1.CaveatOperation_OR},
+		},
+	}
+}
+
+func isAlwaysFalse(expr *v1.CaveatExpression) bool {
+	op, ok := expr.GetOperationOrCaveat().(*v1.CaveatExpression_Operation)
+	return ok && op.Operation.Op == v1.CaveatOperation_OR && len(op.Operation.Children) == 0
+}
+
+func flip(op v1.CaveatOperation_Operation) v1.CaveatOperation_Operation {
+	if op == v1.CaveatOperation_AND {
+		return v1.CaveatOperation_OR
+	}
+	return v1.CaveatOperation_AND
+}
+
+func negate(expr *v1.CaveatExpression) *v1.CaveatExpression {
+	return &v1.CaveatExpression{
+		OperationOrCaveat: &v1.CaveatExpression_Operation{
+			Operation: &v1.CaveatOperation{Op: v1.CaveatOperation_NOT, Children: []*v1.CaveatExpression{expr}},
+		},
+	}
+}
+
+// hashExpr computes a stable structural hash of expr, used to detect duplicate and complementary
+// children without relying on pointer identity. It walks the expression itself rather than
+// marshaling the proto message, since deterministic proto marshaling makes no guarantee of
+// stability for the map fields nested inside a caveat's context.
+func hashExpr(expr *v1.CaveatExpression) string {
+	sum := sha256.Sum256([]byte(canonicalString(expr)))
+	return hex.EncodeToString(sum[:])
+}
+
+func canonicalString(expr *v1.CaveatExpression) string {
+	if expr == nil {
+		return "true"
+	}
+
+	switch t := expr.OperationOrCaveat.(type) {
+	case *v1.CaveatExpression_Caveat:
+		return fmt.Sprintf("caveat(%s,%v)", t.Caveat.GetCaveatName(), t.Caveat.GetContext())
+	case *v1.CaveatExpression_Operation:
+		children := make([]string, 0, len(t.Operation.Children))
+		for _, child := range t.Operation.Children {
+			children = append(children, canonicalString(child))
+		}
+		sort.Strings(children)
+		return fmt.Sprintf("%s(%s)", t.Operation.Op, children)
+	default:
+		return fmt.Sprintf("unknown:%v", expr)
+	}
+}
@@ -0,0 +1,122 @@
+package graph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	core "github.com/authzed/spicedb/pkg/proto/core/v1"
+	v1 "github.com/authzed/spicedb/pkg/proto/dispatch/v1"
+)
+
+func leaf(name string) *v1.CaveatExpression {
+	return wrapCaveat(&core.ContextualizedCaveat{CaveatName: name})
+}
+
+func and(children ...*v1.CaveatExpression) *v1.CaveatExpression {
+	return &v1.CaveatExpression{
+		OperationOrCaveat: &v1.CaveatExpression_Operation{
+			Operation: &v1.CaveatOperation{Op: v1.CaveatOperation_AND, Children: children},
+		},
+	}
+}
+
+func or(children ...*v1.CaveatExpression) *v1.CaveatExpression {
+	return &v1.CaveatExpression{
+		OperationOrCaveat: &v1.CaveatExpression_Operation{
+			Operation: &v1.CaveatOperation{Op: v1.CaveatOperation_OR, Children: children},
+		},
+	}
+}
+
+func not(child *v1.CaveatExpression) *v1.CaveatExpression {
+	return negate(child)
+}
+
+func TestSimplifyFlattensAssociativeChains(t *testing.T) {
+	expr := and(leaf("a"), and(leaf("b"), leaf("c")))
+
+	simplified := Simplify(expr)
+
+	op, ok := simplified.OperationOrCaveat.(*v1.CaveatExpression_Operation)
+	require.True(t, ok)
+	require.Equal(t, v1.CaveatOperation_AND, op.Operation.Op)
+	require.Len(t, op.Operation.Children, 3)
+}
+
+func TestSimplifyDeduplicatesEqualChildren(t *testing.T) {
+	expr := or(leaf("a"), leaf("a"), leaf("b"))
+
+	simplified := Simplify(expr)
+
+	op, ok := simplified.OperationOrCaveat.(*v1.CaveatExpression_Operation)
+	require.True(t, ok)
+	require.Len(t, op.Operation.Children, 2)
+}
+
+func TestSimplifyDoubleNegationCancels(t *testing.T) {
+	expr := not(not(leaf("a")))
+
+	simplified := Simplify(expr)
+
+	require.Equal(t, hashExpr(leaf("a")), hashExpr(simplified))
+}
+
+func TestSimplifyDeMorganPushesNotsToLeaves(t *testing.T) {
+	expr := not(and(leaf("a"), leaf("b")))
+
+	simplified := Simplify(expr)
+
+	op, ok := simplified.OperationOrCaveat.(*v1.CaveatExpression_Operation)
+	require.True(t, ok)
+	require.Equal(t, v1.CaveatOperation_OR, op.Operation.Op)
+	require.Len(t, op.Operation.Children, 2)
+	for _, child := range op.Operation.Children {
+		childOp, ok := child.OperationOrCaveat.(*v1.CaveatExpression_Operation)
+		require.True(t, ok)
+		require.Equal(t, v1.CaveatOperation_NOT, childOp.Operation.Op)
+	}
+}
+
+func TestSimplifyAndWithComplementIsAlwaysFalse(t *testing.T) {
+	expr := and(leaf("a"), not(leaf("a")), leaf("b"))
+
+	simplified := Simplify(expr)
+
+	require.True(t, isAlwaysFalse(simplified))
+}
+
+func TestSimplifyOrWithComplementIsAlwaysTrue(t *testing.T) {
+	expr := or(leaf("a"), not(leaf("a")), leaf("b"))
+
+	simplified := Simplify(expr)
+
+	require.Nil(t, simplified)
+}
+
+func TestSimplifyAbsorption(t *testing.T) {
+	// A ∧ (A ∨ B) -> A
+	expr := and(leaf("a"), or(leaf("a"), leaf("b")))
+
+	simplified := Simplify(expr)
+
+	require.Equal(t, hashExpr(leaf("a")), hashExpr(simplified))
+}
+
+func TestSimplifyOrAbsorption(t *testing.T) {
+	// A ∨ (A ∧ B) -> A
+	expr := or(leaf("a"), and(leaf("a"), leaf("b")))
+
+	simplified := Simplify(expr)
+
+	require.Equal(t, hashExpr(leaf("a")), hashExpr(simplified))
+}
+
+func TestSimplifyIsIdempotent(t *testing.T) {
+	expr := and(leaf("a"), or(leaf("b"), leaf("a")), not(not(leaf("c"))))
+
+	once := Simplify(expr)
+	twice := Simplify(once)
+
+	require.Equal(t, hashExpr(once), hashExpr(twice))
+}
@@ -57,8 +57,7 @@ func (ms *MembershipSet) AddMemberViaRelationship(
 func (ms *MembershipSet) addMember(resourceID string, caveatExpr *v1.CaveatExpression) {
 	existing, ok := ms.membersByID[resourceID]
 	if !ok {
-		ms.hasDeterminedMember = ms.hasDeterminedMember || caveatExpr == nil
-		ms.membersByID[resourceID] = caveatExpr
+		ms.setMember(resourceID, caveatExpr)
 		return
 	}
 
@@ -76,7 +75,22 @@ func (ms *MembershipSet) addMember(resourceID string, caveatExpr *v1.CaveatExpre
 	}
 
 	// Otherwise, the caveats get unioned together.
-	ms.membersByID[resourceID] = caveatOr(existing, caveatExpr)
+	ms.setMember(resourceID, caveatOr(existing, caveatExpr))
+}
+
+// setMember normalizes caveatExpr via Simplify before storing it, so that repeated combination
+// of expressions for the same resource ID doesn't build an unbounded tree. If normalization
+// determines the expression is statically false, the resource ID is dropped from the set
+// entirely, since it can never actually be a member.
+func (ms *MembershipSet) setMember(resourceID string, caveatExpr *v1.CaveatExpression) {
+	normalized := Simplify(caveatExpr)
+	if isAlwaysFalse(normalized) {
+		delete(ms.membersByID, resourceID)
+		return
+	}
+
+	ms.hasDeterminedMember = ms.hasDeterminedMember || normalized == nil
+	ms.membersByID[resourceID] = normalized
 }
 
 // UnionWith combines the results found in the given map with the members of this set.
@@ -107,7 +121,7 @@ func (ms *MembershipSet) IntersectWith(resultsMap CheckResultsMap) {
 			continue
 		}
 
-		ms.membersByID[resourceID] = caveatAnd(existing, details.Expression)
+		ms.setMember(resourceID, caveatAnd(existing, details.Expression))
 	}
 }
 
@@ -125,7 +139,7 @@ func (ms *MembershipSet) Subtract(resultsMap CheckResultsMap) {
 
 			// Otherwise, the caveat expression gets combined with an intersection of the inversion
 			// of the expression.
-			ms.membersByID[resourceID] = caveatSub(expression, details.Expression)
+			ms.setMember(resourceID, caveatSub(expression, details.Expression))
 		} else {
 			if expression == nil {
 				ms.hasDeterminedMember = true
@@ -149,14 +163,22 @@ func (ms *MembershipSet) HasDeterminedMember() bool {
 func (ms *MembershipSet) AsCheckResultsMap() CheckResultsMap {
 	resultsMap := make(CheckResultsMap, len(ms.membersByID))
 	for resourceID, caveat := range ms.membersByID {
+		normalized := Simplify(caveat)
+
+		// A statically-false expression means this resource ID can never actually be a member,
+		// regardless of context, so it's excluded rather than reported as caveated.
+		if isAlwaysFalse(normalized) {
+			continue
+		}
+
 		membership := v1.DispatchCheckResponse_MEMBER
-		if caveat != nil {
+		if normalized != nil {
 			membership = v1.DispatchCheckResponse_CAVEATED_MEMBER
 		}
 
 		resultsMap[resourceID] = &v1.DispatchCheckResponse_ResourceCheckResult{
 			Membership: membership,
-			Expression: caveat,
+			Expression: normalized,
 		}
 	}
 
@@ -242,4 +264,4 @@ func caveatSub(caveat *v1.CaveatExpression, subtraction *v1.CaveatExpression) *v
 			},
 		},
 	}
-}
\ No newline at end of file
+}
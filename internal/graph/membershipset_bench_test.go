@@ -0,0 +1,36 @@
+package graph
+
+import (
+	"strconv"
+	"testing"
+
+	core "github.com/authzed/spicedb/pkg/proto/core/v1"
+	v1 "github.com/authzed/spicedb/pkg/proto/dispatch/v1"
+)
+
+// BenchmarkMembershipSetRepeatedIntersect exercises the worst-case tree growth pattern for
+// Simplify: repeatedly intersecting the same resource IDs, under the same caveat, against the
+// set. Without normalization this builds a deeply-nested AND tree with the same caveat
+// duplicated at every level; with Simplify it should collapse back down to a single leaf.
+func BenchmarkMembershipSetRepeatedIntersect(b *testing.B) {
+	const resourceCount = 50
+	const intersectCount = 50
+
+	resultsMap := make(CheckResultsMap, resourceCount)
+	for i := 0; i < resourceCount; i++ {
+		resultsMap[strconv.Itoa(i)] = &v1.DispatchCheckResponse_ResourceCheckResult{
+			Membership: v1.DispatchCheckResponse_CAVEATED_MEMBER,
+			Expression: wrapCaveat(&core.ContextualizedCaveat{CaveatName: "somecaveat"}),
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ms := membershipSetFromMap(map[string]*v1.CaveatExpression{})
+		ms.UnionWith(resultsMap)
+		for j := 0; j < intersectCount; j++ {
+			ms.IntersectWith(resultsMap)
+		}
+		_ = ms.AsCheckResultsMap()
+	}
+}